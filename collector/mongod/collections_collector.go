@@ -0,0 +1,243 @@
+package mongod
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionsCollector implements prometheus.Collector for collection-level
+// collStats and $indexStats data. Unlike the package-level GaugeVec pattern
+// used elsewhere in this package, it holds no mutable state between scrapes:
+// Collect fetches a fresh snapshot and turns it directly into
+// prometheus.MustNewConstMetric values, so two overlapping scrapes (e.g. from
+// federated Prometheus or Agent-mode remote_write) can never stomp on each
+// other's data, and a CollectionsCollector can be registered against an
+// isolated prometheus.Registry in tests.
+type CollectionsCollector struct {
+	client *mongo.Client
+
+	// filter holds only immutable --collector.collstats.* rules; each
+	// Collect call derives its own --collector.collstats.limit budget from
+	// it (see CollStatsFilter.NewBudget), so filter itself can be shared
+	// across overlapping scrapes and across the collStats/$indexStats
+	// passes without one starving the other.
+	filter *CollStatsFilter
+
+	collectionSize        *prometheus.Desc
+	collectionObjectCount *prometheus.Desc
+	collectionAvgObjSize  *prometheus.Desc
+	collectionStorageSize *prometheus.Desc
+	collectionIndexes     *prometheus.Desc
+	collectionIndexesSize *prometheus.Desc
+	collectionIndexSize   *prometheus.Desc
+
+	wtBlockManagerBlocksTotal    *prometheus.Desc
+	wtCachePages                 *prometheus.Desc
+	wtCachePagesTotal            *prometheus.Desc
+	wtCacheBytes                 *prometheus.Desc
+	wtCacheBytesTotal            *prometheus.Desc
+	wtCacheEvictedTotal          *prometheus.Desc
+	wtCacheEvictionQueueLength   *prometheus.Desc
+	wtCacheHazardBlockedTotal    *prometheus.Desc
+	wtCacheAppThreadOpsTotal     *prometheus.Desc
+	wtCacheAppThreadSecsTotal    *prometheus.Desc
+	wtLogBytesTotal              *prometheus.Desc
+	wtLogOperationsTotal         *prometheus.Desc
+	wtTransactionUpdateConflicts *prometheus.Desc
+	wtOpenCursors                *prometheus.Desc
+
+	scrapeDurationSeconds *prometheus.Desc
+	scrapeErrorsTotal     *prometheus.Desc
+	collectionsScraped    *prometheus.Desc
+
+	indexUsageOpsTotal     *prometheus.Desc
+	indexUsageSinceSeconds *prometheus.Desc
+	indexUnused            *prometheus.Desc
+}
+
+// NewCollectionsCollector returns a CollectionsCollector that scrapes client
+// through filter every time it is collected. Register it against a
+// prometheus.Registerer with Register or MustRegister.
+func NewCollectionsCollector(client *mongo.Client, filter *CollStatsFilter) *CollectionsCollector {
+	if filter == nil {
+		filter = &CollStatsFilter{}
+	}
+
+	dbColl := []string{"db", "coll"}
+	dbCollType := []string{"db", "coll", "type"}
+	dbCollIndex := []string{"db", "coll", "index"}
+
+	return &CollectionsCollector{
+		client: client,
+		filter: filter,
+
+		collectionSize:        prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "size"), "The total size in memory of all records in a collection", dbColl, nil),
+		collectionObjectCount: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "count"), "The number of objects or documents in this collection", dbColl, nil),
+		collectionAvgObjSize:  prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "avgobjsize"), "The average size of an object in the collection (plus any padding)", dbColl, nil),
+		collectionStorageSize: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "storage_size"), "The total amount of storage allocated to this collection for document storage", dbColl, nil),
+		collectionIndexes:     prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "indexes"), "The number of indexes on the collection", dbColl, nil),
+		collectionIndexesSize: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "indexes_size"), "The total size of all indexes", dbColl, nil),
+		collectionIndexSize:   prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_coll", "index_size"), "The individual index size", dbCollIndex, nil),
+
+		wtBlockManagerBlocksTotal:    prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_blockmanager", "blocks_total"), "The total number of blocks allocated by the WiredTiger BlockManager", dbCollType, nil),
+		wtCachePages:                 prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "pages"), "The current number of pages in the WiredTiger Cache", dbCollType, nil),
+		wtCachePagesTotal:            prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "pages_total"), "The total number of pages read into/from the WiredTiger Cache", dbCollType, nil),
+		wtCacheBytes:                 prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "bytes"), "The current size of data in the WiredTiger Cache in bytes", dbCollType, nil),
+		wtCacheBytesTotal:            prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "bytes_total"), "The total number of bytes read into/from the WiredTiger Cache", dbCollType, nil),
+		wtCacheEvictedTotal:          prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "evicted_total"), "The total number of pages evicted from the WiredTiger Cache", dbCollType, nil),
+		wtCacheEvictionQueueLength:   prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "eviction_queue_length"), "The current number of pages queued for eviction from the WiredTiger Cache", dbColl, nil),
+		wtCacheHazardBlockedTotal:    prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "hazard_pointer_blocked_total"), "The total number of page evictions blocked because a hazard pointer was held on the page", dbColl, nil),
+		wtCacheAppThreadOpsTotal:     prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "app_thread_page_ops_total"), "The total number of pages moved between disk and the WiredTiger Cache directly by application threads", dbCollType, nil),
+		wtCacheAppThreadSecsTotal:    prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_cache", "app_thread_page_seconds_total"), "The total time spent by application threads moving pages between disk and the WiredTiger Cache", dbCollType, nil),
+		wtLogBytesTotal:              prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_log", "bytes_total"), "The total number of bytes written to the WiredTiger log", dbCollType, nil),
+		wtLogOperationsTotal:         prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_log", "operations_total"), "The total number of WiredTiger log operations", dbCollType, nil),
+		wtTransactionUpdateConflicts: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_transactions", "update_conflicts"), "The number of conflicts updating transactions", dbColl, nil),
+		wtOpenCursors:                prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collection_wiredtiger_session", "open_cursors_total"), "The total number of cursors opened in WiredTiger", dbColl, nil),
+
+		scrapeDurationSeconds: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collstats_scrape", "duration_seconds"), "The wall-clock time the last collStats scrape took to gather all allowed collections", nil, nil),
+		scrapeErrorsTotal:     prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collstats_scrape", "errors_total"), "Whether collStats failed for this collection during the last scrape", dbColl, nil),
+		collectionsScraped:    prometheus.NewDesc(prometheus.BuildFQName(Namespace, "collstats", "collections_scraped"), "The number of collections whose collStats data was exported during the last scrape; collections dropped by --collector.collstats.min-size are not counted", nil, nil),
+
+		indexUsageOpsTotal:     prometheus.NewDesc(prometheus.BuildFQName(Namespace, "index_usage", "ops_total"), "The number of operations that have used this index, as reported by $indexStats", dbCollIndex, nil),
+		indexUsageSinceSeconds: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "index_usage", "since_seconds"), "Unix timestamp of the time from which MongoDB gathered the index usage statistics, as reported by $indexStats", dbCollIndex, nil),
+		indexUnused:            prometheus.NewDesc(prometheus.BuildFQName(Namespace, "index", "unused"), "1 if this index has recorded zero ops for at least --collector.indexstats.unused-since, 0 otherwise", dbCollIndex, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *CollectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.collectionSize
+	ch <- c.collectionObjectCount
+	ch <- c.collectionAvgObjSize
+	ch <- c.collectionStorageSize
+	ch <- c.collectionIndexes
+	ch <- c.collectionIndexesSize
+	ch <- c.collectionIndexSize
+
+	ch <- c.wtBlockManagerBlocksTotal
+	ch <- c.wtCachePages
+	ch <- c.wtCachePagesTotal
+	ch <- c.wtCacheBytes
+	ch <- c.wtCacheBytesTotal
+	ch <- c.wtCacheEvictedTotal
+	ch <- c.wtCacheEvictionQueueLength
+	ch <- c.wtCacheHazardBlockedTotal
+	ch <- c.wtCacheAppThreadOpsTotal
+	ch <- c.wtCacheAppThreadSecsTotal
+	ch <- c.wtLogBytesTotal
+	ch <- c.wtLogOperationsTotal
+	ch <- c.wtTransactionUpdateConflicts
+	ch <- c.wtOpenCursors
+
+	ch <- c.scrapeDurationSeconds
+	ch <- c.scrapeErrorsTotal
+	ch <- c.collectionsScraped
+
+	ch <- c.indexUsageOpsTotal
+	ch <- c.indexUsageSinceSeconds
+	ch <- c.indexUnused
+}
+
+// Collect implements prometheus.Collector. It fetches a fresh snapshot on
+// every call and builds all metrics from it with MustNewConstMetric, so no
+// state survives between scrapes and concurrent scrapes cannot interleave
+// writes to shared collectors.
+func (c *CollectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	collStats := getCollectionStatList(ctx, c.client, c.filter)
+	if collStats != nil {
+		c.collectCollectionStats(ch, collStats)
+	}
+
+	indexStats := getIndexUsageStatList(ctx, c.client, c.filter)
+	if indexStats != nil {
+		c.collectIndexUsageStats(ch, indexStats)
+	}
+}
+
+func (c *CollectionsCollector) collectCollectionStats(ch chan<- prometheus.Metric, stats *CollectionStatList) {
+	for _, member := range stats.Members {
+		db, coll := member.Database, member.Name
+
+		ch <- prometheus.MustNewConstMetric(c.collectionSize, prometheus.GaugeValue, float64(member.Size), db, coll)
+		ch <- prometheus.MustNewConstMetric(c.collectionObjectCount, prometheus.GaugeValue, float64(member.Count), db, coll)
+		ch <- prometheus.MustNewConstMetric(c.collectionAvgObjSize, prometheus.GaugeValue, float64(member.AvgObjSize), db, coll)
+		ch <- prometheus.MustNewConstMetric(c.collectionStorageSize, prometheus.GaugeValue, float64(member.StorageSize), db, coll)
+		ch <- prometheus.MustNewConstMetric(c.collectionIndexes, prometheus.GaugeValue, float64(len(member.IndexSizes)), db, coll)
+		ch <- prometheus.MustNewConstMetric(c.collectionIndexesSize, prometheus.GaugeValue, float64(member.IndexesSize), db, coll)
+		for indexName, size := range member.IndexSizes {
+			ch <- prometheus.MustNewConstMetric(c.collectionIndexSize, prometheus.GaugeValue, size, db, coll, indexName)
+		}
+
+		if member.WiredTiger != nil {
+			c.collectWiredTigerStats(ch, db, coll, member.WiredTiger)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.scrapeDurationSeconds, prometheus.GaugeValue, stats.ScrapeDurationSeconds)
+	ch <- prometheus.MustNewConstMetric(c.collectionsScraped, prometheus.GaugeValue, float64(len(stats.Members)))
+	for _, scrapeErr := range stats.ScrapeErrors {
+		ch <- prometheus.MustNewConstMetric(c.scrapeErrorsTotal, prometheus.GaugeValue, 1, scrapeErr.Database, scrapeErr.Collection)
+	}
+}
+
+func (c *CollectionsCollector) collectWiredTigerStats(ch chan<- prometheus.Metric, db, coll string, stats *CollWiredTigerStats) {
+	if bm := stats.BlockManager; bm != nil {
+		ch <- prometheus.MustNewConstMetric(c.wtBlockManagerBlocksTotal, prometheus.GaugeValue, bm.BlocksFreed, db, coll, "freed")
+		ch <- prometheus.MustNewConstMetric(c.wtBlockManagerBlocksTotal, prometheus.GaugeValue, bm.BlocksAllocated, db, coll, "allocated")
+	}
+
+	if cache := stats.Cache; cache != nil {
+		ch <- prometheus.MustNewConstMetric(c.wtCachePagesTotal, prometheus.GaugeValue, cache.PagesReadInto, db, coll, "read")
+		ch <- prometheus.MustNewConstMetric(c.wtCachePagesTotal, prometheus.GaugeValue, cache.PagesWrittenFrom, db, coll, "written")
+		ch <- prometheus.MustNewConstMetric(c.wtCachePagesTotal, prometheus.GaugeValue, cache.PagesRequested, db, coll, "requested")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheBytesTotal, prometheus.GaugeValue, cache.BytesReadInto, db, coll, "read")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheBytesTotal, prometheus.GaugeValue, cache.BytesWrittenFrom, db, coll, "written")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheEvictedTotal, prometheus.GaugeValue, cache.EvictedModified, db, coll, "modified")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheEvictedTotal, prometheus.GaugeValue, cache.EvictedUnmodified, db, coll, "unmodified")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheEvictedTotal, prometheus.GaugeValue, cache.EvictedInternal, db, coll, "internal")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheEvictedTotal, prometheus.GaugeValue, cache.EvictedByAppThread, db, coll, "application")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheBytes, prometheus.GaugeValue, cache.BytesTotal, db, coll, "total")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheBytes, prometheus.GaugeValue, cache.BytesDirty, db, coll, "dirty")
+		ch <- prometheus.MustNewConstMetric(c.wtCachePages, prometheus.GaugeValue, cache.PagesDirty, db, coll, "dirty")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheEvictionQueueLength, prometheus.GaugeValue, cache.PagesQueuedEviction, db, coll)
+		ch <- prometheus.MustNewConstMetric(c.wtCacheHazardBlockedTotal, prometheus.GaugeValue, cache.HazardPointerBlocked, db, coll)
+		ch <- prometheus.MustNewConstMetric(c.wtCacheAppThreadOpsTotal, prometheus.GaugeValue, cache.AppThreadReadCount, db, coll, "read")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheAppThreadOpsTotal, prometheus.GaugeValue, cache.AppThreadWriteCount, db, coll, "written")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheAppThreadSecsTotal, prometheus.GaugeValue, cache.AppThreadReadTime/1000000, db, coll, "read")
+		ch <- prometheus.MustNewConstMetric(c.wtCacheAppThreadSecsTotal, prometheus.GaugeValue, cache.AppThreadWriteTime/1000000, db, coll, "written")
+	}
+
+	if logStats := stats.Log; logStats != nil {
+		ch <- prometheus.MustNewConstMetric(c.wtLogBytesTotal, prometheus.GaugeValue, logStats.BytesWritten, db, coll, "written")
+		ch <- prometheus.MustNewConstMetric(c.wtLogBytesTotal, prometheus.GaugeValue, logStats.BytesPayload, db, coll, "payload")
+		ch <- prometheus.MustNewConstMetric(c.wtLogOperationsTotal, prometheus.GaugeValue, logStats.RecordsScanned, db, coll, "records_scanned")
+		ch <- prometheus.MustNewConstMetric(c.wtLogOperationsTotal, prometheus.GaugeValue, logStats.SyncOperations, db, coll, "sync")
+		ch <- prometheus.MustNewConstMetric(c.wtLogOperationsTotal, prometheus.GaugeValue, logStats.SyncDirOperations, db, coll, "sync_dir")
+		ch <- prometheus.MustNewConstMetric(c.wtLogOperationsTotal, prometheus.GaugeValue, logStats.FlushOperations, db, coll, "flush")
+	}
+
+	if tx := stats.Transaction; tx != nil {
+		ch <- prometheus.MustNewConstMetric(c.wtTransactionUpdateConflicts, prometheus.GaugeValue, tx.UpdateConflicts, db, coll)
+	}
+
+	if session := stats.Session; session != nil {
+		ch <- prometheus.MustNewConstMetric(c.wtOpenCursors, prometheus.GaugeValue, session.Cursors, db, coll)
+	}
+}
+
+func (c *CollectionsCollector) collectIndexUsageStats(ch chan<- prometheus.Metric, stats *IndexUsageStatList) {
+	for _, member := range stats.Members {
+		ch <- prometheus.MustNewConstMetric(c.indexUsageOpsTotal, prometheus.GaugeValue, member.Ops, member.Database, member.Collection, member.Index)
+		ch <- prometheus.MustNewConstMetric(c.indexUsageSinceSeconds, prometheus.GaugeValue, float64(member.Since.Unix()), member.Database, member.Collection, member.Index)
+
+		unused := 0.0
+		if member.Unused() {
+			unused = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(c.indexUnused, prometheus.GaugeValue, unused, member.Database, member.Collection, member.Index)
+	}
+}
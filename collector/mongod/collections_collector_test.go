@@ -0,0 +1,82 @@
+package mongod
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// snapshotCollector drives a CollectionsCollector's metric-building logic
+// from a fixed, already-fetched snapshot instead of a live mongo.Client, so
+// the Desc/label wiring can be golden-tested without a running mongod.
+type snapshotCollector struct {
+	*CollectionsCollector
+	collStats  *CollectionStatList
+	indexStats *IndexUsageStatList
+}
+
+func (c *snapshotCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collectCollectionStats(ch, c.collStats)
+	c.collectIndexUsageStats(ch, c.indexStats)
+}
+
+func TestCollectionsCollectorGolden(t *testing.T) {
+	collector := &snapshotCollector{
+		CollectionsCollector: NewCollectionsCollector(nil, &CollStatsFilter{}),
+		collStats: &CollectionStatList{
+			Members: []CollectionStatus{
+				{
+					Database:    "app",
+					Name:        "widgets",
+					Size:        100,
+					Count:       10,
+					AvgObjSize:  10,
+					StorageSize: 200,
+					IndexesSize: 50,
+				},
+			},
+			ScrapeDurationSeconds: 0.25,
+		},
+		indexStats: &IndexUsageStatList{
+			Members: []IndexUsageStatus{
+				{Database: "app", Collection: "widgets", Index: "_id_", Ops: 42, Since: time.Unix(1700000000, 0)},
+			},
+		},
+	}
+
+	expected := `
+# HELP mongodb_db_coll_size The total size in memory of all records in a collection
+# TYPE mongodb_db_coll_size gauge
+mongodb_db_coll_size{coll="widgets",db="app"} 100
+# HELP mongodb_index_usage_ops_total The number of operations that have used this index, as reported by $indexStats
+# TYPE mongodb_index_usage_ops_total gauge
+mongodb_index_usage_ops_total{coll="widgets",db="app",index="_id_"} 42
+`
+
+	if err := promtest.CollectAndCompare(collector, strings.NewReader(expected),
+		"mongodb_db_coll_size", "mongodb_index_usage_ops_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCollectionsCollectorSharedFilterBudget guards against the limit
+// accounting regressing back onto the shared *CollStatsFilter: two budgets
+// drawn from the same filter, as two overlapping Collect calls of a
+// CollectionsCollector draw them, must each admit their own full quota of
+// namespaces.
+func TestCollectionsCollectorSharedFilterBudget(t *testing.T) {
+	filter := &CollStatsFilter{limit: 1}
+
+	budgetA := filter.NewBudget()
+	budgetB := filter.NewBudget()
+
+	if !filter.AllowNamespace("app", "widgets", budgetA) {
+		t.Fatal("expected the first scrape's budget to admit its own namespace")
+	}
+	if !filter.AllowNamespace("app", "widgets", budgetB) {
+		t.Fatal("expected a second, independent scrape's budget to admit the same namespace rather than being starved by the first")
+	}
+}
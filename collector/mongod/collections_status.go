@@ -2,8 +2,10 @@ package mongod
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,174 +14,32 @@ import (
 	"github.com/percona/mongodb_exporter/shared"
 )
 
-var (
-	collectionSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "size",
-		Help:      "The total size in memory of all records in a collection",
-	}, []string{"db", "coll"})
-	collectionObjectCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "count",
-		Help:      "The number of objects or documents in this collection",
-	}, []string{"db", "coll"})
-	collectionAvgObjSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "avgobjsize",
-		Help:      "The average size of an object in the collection (plus any padding)",
-	}, []string{"db", "coll"})
-	collectionStorageSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "storage_size",
-		Help:      "The total amount of storage allocated to this collection for document storage",
-	}, []string{"db", "coll"})
-	collectionIndexes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "indexes",
-		Help:      "The number of indexes on the collection",
-	}, []string{"db", "coll"})
-	collectionIndexesSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "indexes_size",
-		Help:      "The total size of all indexes",
-	}, []string{"db", "coll"})
-	collectionIndexSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "db_coll",
-		Name:      "index_size",
-		Help:      "The individual index size",
-	}, []string{"db", "coll", "index"})
-)
-
-var (
-	collWTBlockManagerBlocksTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_blockmanager",
-		Name:      "blocks_total",
-		Help:      "The total number of blocks allocated by the WiredTiger BlockManager",
-	}, []string{"db", "coll", "type"})
-)
-
-var (
-	collWTCachePages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_cache",
-		Name:      "pages",
-		Help:      "The current number of pages in the WiredTiger Cache",
-	}, []string{"db", "coll", "type"})
-	collWTCachePagesTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_cache",
-		Name:      "pages_total",
-		Help:      "The total number of pages read into/from the WiredTiger Cache",
-	}, []string{"db", "coll", "type"})
-	collWTCacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_cache",
-		Name:      "bytes",
-		Help:      "The current size of data in the WiredTiger Cache in bytes",
-	}, []string{"db", "coll", "type"})
-	collWTCacheBytesTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_cache",
-		Name:      "bytes_total",
-		Help:      "The total number of bytes read into/from the WiredTiger Cache",
-	}, []string{"db", "coll", "type"})
-	collWTCacheEvictedTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_cache",
-		Name:      "evicted_total",
-		Help:      "The total number of pages evicted from the WiredTiger Cache",
-	}, []string{"db", "coll", "type"})
-)
-
-var (
-	collWTTransactionsUpdateConflicts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_transactions",
-		Name:      "update_conflicts",
-		Help:      "The number of conflicts updating transactions",
-	}, []string{"db", "coll"})
-)
-
-var (
-	collWTOpenCursors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Subsystem: "collection_wiredtiger_session",
-		Name:      "open_cursors_total",
-		Help:      "The total number of cursors opened in WiredTiger",
-	}, []string{"db", "coll"})
-)
-
 // CollWTBlockManagerStats defines the blockmanager stats
 type CollWTBlockManagerStats struct {
 	BlocksFreed     float64 `bson:"blocks freed"`
 	BlocksAllocated float64 `bson:"blocks allocated"`
 }
 
-// Export exports the collection block manager stats to prometheus
-func (stats *CollWTBlockManagerStats) Export(ch chan<- prometheus.Metric, db, collection string) {
-	collWTBlockManagerBlocksTotal.With(stats.labels(db, collection, "freed")).Set(stats.BlocksFreed)
-	collWTBlockManagerBlocksTotal.With(stats.labels(db, collection, "allocated")).Set(stats.BlocksAllocated)
-}
-
-// Describe describes collection block manager stats for prometheus
-func (stats *CollWTBlockManagerStats) Describe(ch chan<- *prometheus.Desc) {
-	collWTBlockManagerBlocksTotal.Describe(ch)
-}
-
-func (stats *CollWTBlockManagerStats) labels(db, coll, kind string) prometheus.Labels {
-	return prometheus.Labels{
-		"db":   db,
-		"coll": coll,
-		"type": kind,
-	}
-}
-
 // CollWTCacheStats defines the cache stats
 type CollWTCacheStats struct {
-	BytesTotal        float64 `bson:"bytes currently in the cache"`
-	BytesDirty        float64 `bson:"tracked dirty bytes in the cache"`
-	BytesReadInto     float64 `bson:"bytes read into cache"`
-	BytesWrittenFrom  float64 `bson:"bytes written from cache"`
-	EvictedUnmodified float64 `bson:"unmodified pages evicted"`
-	EvictedModified   float64 `bson:"modified pages evicted"`
-	PagesReadInto     float64 `bson:"pages read into cache"`
-	PagesWrittenFrom  float64 `bson:"pages written from cache"`
-}
-
-// Export exports the collection cache stats to prometheus
-func (stats *CollWTCacheStats) Export(ch chan<- prometheus.Metric, db, collection string) {
-	collWTCachePagesTotal.With(stats.labels(db, collection, "read")).Set(stats.PagesReadInto)
-	collWTCachePagesTotal.With(stats.labels(db, collection, "written")).Set(stats.PagesWrittenFrom)
-	collWTCacheBytesTotal.With(stats.labels(db, collection, "read")).Set(stats.BytesReadInto)
-	collWTCacheBytesTotal.With(stats.labels(db, collection, "written")).Set(stats.BytesWrittenFrom)
-	collWTCacheEvictedTotal.With(stats.labels(db, collection, "modified")).Set(stats.EvictedModified)
-	collWTCacheEvictedTotal.With(stats.labels(db, collection, "unmodified")).Set(stats.EvictedUnmodified)
-	collWTCacheBytes.With(stats.labels(db, collection, "total")).Set(stats.BytesTotal)
-	collWTCacheBytes.With(stats.labels(db, collection, "dirty")).Set(stats.BytesDirty)
-}
-
-// Describe describes the stats to prometheus
-func (stats *CollWTCacheStats) Describe(ch chan<- *prometheus.Desc) {
-	collWTCachePagesTotal.Describe(ch)
-	collWTCacheEvictedTotal.Describe(ch)
-	collWTCachePages.Describe(ch)
-	collWTCacheBytes.Describe(ch)
-}
-
-func (stats *CollWTCacheStats) labels(db, coll, kind string) prometheus.Labels {
-	return prometheus.Labels{
-		"db":   db,
-		"coll": coll,
-		"type": kind,
-	}
+	BytesTotal           float64 `bson:"bytes currently in the cache"`
+	BytesDirty           float64 `bson:"tracked dirty bytes in the cache"`
+	BytesReadInto        float64 `bson:"bytes read into cache"`
+	BytesWrittenFrom     float64 `bson:"bytes written from cache"`
+	EvictedUnmodified    float64 `bson:"unmodified pages evicted"`
+	EvictedModified      float64 `bson:"modified pages evicted"`
+	EvictedInternal      float64 `bson:"internal pages evicted"`
+	EvictedByAppThread   float64 `bson:"pages evicted by application threads"`
+	PagesReadInto        float64 `bson:"pages read into cache"`
+	PagesWrittenFrom     float64 `bson:"pages written from cache"`
+	PagesRequested       float64 `bson:"pages requested from the cache"`
+	PagesDirty           float64 `bson:"tracked dirty pages in the cache"`
+	PagesQueuedEviction  float64 `bson:"pages queued for eviction"`
+	HazardPointerBlocked float64 `bson:"hazard pointer blocked page eviction"`
+	AppThreadReadCount   float64 `bson:"application threads page read from disk to cache count"`
+	AppThreadReadTime    float64 `bson:"application threads page read from disk to cache time (usecs)"`
+	AppThreadWriteCount  float64 `bson:"application threads page write from cache to disk count"`
+	AppThreadWriteTime   float64 `bson:"application threads page write from cache to disk time (usecs)"`
 }
 
 // CollWTSessionStats defines the session stats
@@ -187,102 +47,50 @@ type CollWTSessionStats struct {
 	Cursors float64 `bson:"open cursor count"`
 }
 
-// Export exports the collection session stats to prometheus
-func (stats *CollWTSessionStats) Export(ch chan<- prometheus.Metric, db, collection string) {
-	collWTOpenCursors.With(prometheus.Labels{
-		"db":   db,
-		"coll": collection,
-	}).Set(stats.Cursors)
-}
-
-// Describe describes collection session stats for prometheus
-func (stats *CollWTSessionStats) Describe(ch chan<- *prometheus.Desc) {
-	collWTOpenCursors.Describe(ch)
-}
-
 // CollWTTransactionStats defines the transaction stats
 type CollWTTransactionStats struct {
 	UpdateConflicts float64 `bson:"update conflicts"`
 }
 
-// Export exports the collection transaction stats to prometheus
-func (stats *CollWTTransactionStats) Export(ch chan<- prometheus.Metric, db, collection string) {
-	collWTTransactionsUpdateConflicts.With(prometheus.Labels{
-		"db":   db,
-		"coll": collection,
-	}).Set(stats.UpdateConflicts)
-}
-
-// Describe describes collection transaction stats for prometheus
-func (stats *CollWTTransactionStats) Describe(ch chan<- *prometheus.Desc) {
-	collWTTransactionsUpdateConflicts.Describe(ch)
+// CollWTLogStats defines the collection-level WiredTiger log stats. Log
+// stats are connection-scoped in WiredTiger, so a per-collection collStats
+// document may not carry a "log" subtree at all; when it doesn't, Log on
+// CollWiredTigerStats decodes nil and the collection_wiredtiger_log_*
+// metrics are simply not emitted for that collection.
+type CollWTLogStats struct {
+	BytesWritten      float64 `bson:"log bytes written"`
+	BytesPayload      float64 `bson:"log bytes of payload data"`
+	RecordsScanned    float64 `bson:"records processed by log scan"`
+	SyncOperations    float64 `bson:"log sync operations"`
+	SyncDirOperations float64 `bson:"log sync_dir operations"`
+	FlushOperations   float64 `bson:"log flush operations"`
 }
 
 // CollWiredTigerStats defines the collection WiredTiger stats
 type CollWiredTigerStats struct {
 	BlockManager *CollWTBlockManagerStats `bson:"block-manager"`
 	Cache        *CollWTCacheStats        `bson:"cache"`
+	Log          *CollWTLogStats          `bson:"log"`
 	Session      *CollWTSessionStats      `bson:"session"`
 	Transaction  *CollWTTransactionStats  `bson:"transaction"`
 }
 
-// Describe describes collection wired tiger stats for prometheus
-func (stats *CollWiredTigerStats) Describe(ch chan<- *prometheus.Desc) {
-	if stats.BlockManager != nil {
-		stats.BlockManager.Describe(ch)
-	}
-
-	if stats.Cache != nil {
-		stats.Cache.Describe(ch)
-	}
-	if stats.Transaction != nil {
-		stats.Transaction.Describe(ch)
-	}
-	if stats.Session != nil {
-		stats.Session.Describe(ch)
-	}
-}
-
-// Export exports the collection wired tiger stats to prometheus
-func (stats *CollWiredTigerStats) Export(ch chan<- prometheus.Metric, db, collection string) {
-	if stats.BlockManager != nil {
-		stats.BlockManager.Export(ch, db, collection)
-	}
-
-	if stats.Cache != nil {
-		stats.Cache.Export(ch, db, collection)
-	}
-
-	if stats.Transaction != nil {
-		stats.Transaction.Export(ch, db, collection)
-	}
-
-	if stats.Session != nil {
-		stats.Session.Export(ch, db, collection)
-	}
-
-	collWTBlockManagerBlocksTotal.Collect(ch)
-	collWTCachePagesTotal.Collect(ch)
-	collWTCacheBytesTotal.Collect(ch)
-	collWTCacheEvictedTotal.Collect(ch)
-	collWTCachePages.Collect(ch)
-	collWTCacheBytes.Collect(ch)
-	collWTTransactionsUpdateConflicts.Collect(ch)
-	collWTOpenCursors.Collect(ch)
-
-	collWTBlockManagerBlocksTotal.Reset()
-	collWTCachePagesTotal.Reset()
-	collWTCacheBytesTotal.Reset()
-	collWTCacheEvictedTotal.Reset()
-	collWTCachePages.Reset()
-	collWTCacheBytes.Reset()
-	collWTTransactionsUpdateConflicts.Reset()
-	collWTOpenCursors.Reset()
-}
-
 // CollectionStatList contains stats from all collections.
 type CollectionStatList struct {
 	Members []CollectionStatus
+
+	// ScrapeDurationSeconds is the wall-clock time the scrape that produced
+	// Members took to gather all allowed collections.
+	ScrapeDurationSeconds float64
+	// ScrapeErrors is the set of namespaces collStats failed for during the
+	// scrape that produced Members.
+	ScrapeErrors []CollStatsScrapeError
+}
+
+// CollStatsScrapeError identifies a namespace collStats failed for.
+type CollStatsScrapeError struct {
+	Database   string
+	Collection string
 }
 
 // CollectionStatus represents stats about a collection in database (mongod and raw from mongos).
@@ -298,73 +106,41 @@ type CollectionStatus struct {
 	WiredTiger  *CollWiredTigerStats `bson:"wiredTiger"`
 }
 
-// Export exports database stats to prometheus.
-func (collStatList *CollectionStatList) Export(ch chan<- prometheus.Metric) {
-	// reset previously collected values
-	collectionSize.Reset()
-	collectionObjectCount.Reset()
-	collectionAvgObjSize.Reset()
-	collectionStorageSize.Reset()
-	collectionIndexes.Reset()
-	collectionIndexesSize.Reset()
-	collectionIndexSize.Reset()
-	for _, member := range collStatList.Members {
-		ls := prometheus.Labels{
-			"db":   member.Database,
-			"coll": member.Name,
-		}
-		collectionSize.With(ls).Set(float64(member.Size))
-		collectionObjectCount.With(ls).Set(float64(member.Count))
-		collectionAvgObjSize.With(ls).Set(float64(member.AvgObjSize))
-		collectionStorageSize.With(ls).Set(float64(member.StorageSize))
-		collectionIndexes.With(ls).Set(float64(len(member.IndexSizes)))
-		collectionIndexesSize.With(ls).Set(float64(member.IndexesSize))
-		for indexName, size := range member.IndexSizes {
-			ls = prometheus.Labels{
-				"db":    member.Database,
-				"coll":  member.Name,
-				"index": indexName,
-			}
-			collectionIndexSize.With(ls).Set(size)
-		}
-		if member.WiredTiger != nil {
-			member.WiredTiger.Export(ch, member.Database, member.Name)
-		}
-	}
-	collectionSize.Collect(ch)
-	collectionObjectCount.Collect(ch)
-	collectionAvgObjSize.Collect(ch)
-	collectionStorageSize.Collect(ch)
-	collectionIndexes.Collect(ch)
-	collectionIndexesSize.Collect(ch)
-	collectionIndexSize.Collect(ch)
-}
-
-// Describe describes database stats for prometheus.
-func (collStatList *CollectionStatList) Describe(ch chan<- *prometheus.Desc) {
-	collectionSize.Describe(ch)
-	collectionObjectCount.Describe(ch)
-	collectionAvgObjSize.Describe(ch)
-	collectionStorageSize.Describe(ch)
-	collectionIndexes.Describe(ch)
-	collectionIndexesSize.Describe(ch)
-
-	if len(collStatList.Members) > 0 {
-		member := collStatList.Members[0]
-		if member.WiredTiger != nil {
-			member.WiredTiger.Describe(ch)
-		}
-	}
-}
-
 var logSuppressCS = shared.NewSyncStringSet()
 
 const keyCS = ""
 
-// GetCollectionStatList returns stats for all non-system collections.
-func GetCollectionStatList(client *mongo.Client) *CollectionStatList {
+var (
+	defaultCollStatsFilterOnce sync.Once
+	defaultCollStatsFilter     *CollStatsFilter
+)
+
+// GetCollectionStatList returns stats for all non-system collections allowed
+// by the --collector.collstats.* flags. ctx bounds the whole scrape; each
+// collStats command additionally gets its own --collector.collstats.timeout
+// deadline derived from ctx.
+func GetCollectionStatList(ctx context.Context, client *mongo.Client) *CollectionStatList {
+	defaultCollStatsFilterOnce.Do(func() {
+		filter, err := NewCollStatsFilter()
+		if err != nil {
+			log.Warnf("%s. collStats filtering rules from --collector.collstats.config will not be applied.", err)
+			filter = &CollStatsFilter{}
+		}
+		defaultCollStatsFilter = filter
+	})
+
+	return getCollectionStatList(ctx, client, defaultCollStatsFilter)
+}
+
+func getCollectionStatList(ctx context.Context, client *mongo.Client, filter *CollStatsFilter) *CollectionStatList {
+	start := time.Now()
 	collectionStatList := &CollectionStatList{}
-	dbNames, err := client.ListDatabaseNames(context.TODO(), bson.M{})
+	if filter == nil {
+		filter = &CollStatsFilter{}
+	}
+	budget := filter.NewBudget()
+
+	dbNames, err := client.ListDatabaseNames(ctx, bson.M{})
 	if err != nil {
 		if !logSuppressCS.Contains(keyCS) {
 			log.Warnf("%s. Collection stats will not be collected. This log message will be suppressed from now.", err)
@@ -374,12 +150,13 @@ func GetCollectionStatList(client *mongo.Client) *CollectionStatList {
 	}
 
 	logSuppressCS.Delete(keyCS)
+	var candidates []collStatsJob
 	for _, dbName := range dbNames {
 		if common.IsSystemDB(dbName) {
 			continue
 		}
 
-		collNames, err := client.Database(dbName).ListCollectionNames(context.TODO(), bson.M{})
+		collNames, err := client.Database(dbName).ListCollectionNames(ctx, bson.M{})
 		if err != nil {
 			if !logSuppressCS.Contains(dbName) {
 				log.Warnf("%s. Collection stats will not be collected for this db. This log message will be suppressed from now.", err)
@@ -394,23 +171,57 @@ func GetCollectionStatList(client *mongo.Client) *CollectionStatList {
 				continue
 			}
 
-			fullCollName := common.CollFullName(dbName, collName)
-			collStatus := CollectionStatus{}
-			err = client.Database(dbName).RunCommand(context.TODO(), bson.D{{"collStats", collName}, {"scale", 1}}).Decode(&collStatus)
-			if err != nil {
-				if !logSuppressCS.Contains(fullCollName) {
-					log.Warnf("%s. Collection stats will not be collected for this collection. This log message will be suppressed from now.", err)
-					logSuppressCS.Add(fullCollName)
-				}
-				continue
+			candidates = append(candidates, collStatsJob{db: dbName, coll: collName})
+		}
+	}
+
+	// ListCollectionNames does not guarantee a stable order, but
+	// --collector.collstats.limit needs one so the same namespaces are
+	// chosen scrape after scrape instead of whichever the driver happened
+	// to return first.
+	sort.Slice(candidates, func(i, j int) bool {
+		return common.CollFullName(candidates[i].db, candidates[i].coll) < common.CollFullName(candidates[j].db, candidates[j].coll)
+	})
+
+	var jobs []collStatsJob
+	for _, candidate := range candidates {
+		if !filter.AllowNamespace(candidate.db, candidate.coll, budget) {
+			continue
+		}
+
+		jobs = append(jobs, candidate)
+	}
+
+	for _, outcome := range runCollStatsPool(ctx, client, jobs) {
+		fullCollName := common.CollFullName(outcome.job.db, outcome.job.coll)
+		if outcome.err != nil {
+			collectionStatList.ScrapeErrors = append(collectionStatList.ScrapeErrors, CollStatsScrapeError{
+				Database:   outcome.job.db,
+				Collection: outcome.job.coll,
+			})
+			if !logSuppressCS.Contains(fullCollName) {
+				log.Warnf("%s. Collection stats will not be collected for this collection. This log message will be suppressed from now.", outcome.err)
+				logSuppressCS.Add(fullCollName)
 			}
+			continue
+		}
 
-			logSuppressCS.Delete(fullCollName)
-			collStatus.Database = dbName
-			collStatus.Name = collName
-			collectionStatList.Members = append(collectionStatList.Members, collStatus)
+		// AllowSize runs after the namespace already consumed a
+		// --collector.collstats.limit slot: size is only known once
+		// collStats has returned, so it cannot be checked up front the
+		// way the name-based rules in AllowNamespace are.
+		if !filter.AllowSize(outcome.status.Size) {
+			continue
 		}
+
+		logSuppressCS.Delete(fullCollName)
+		collStatus := outcome.status
+		collStatus.Database = outcome.job.db
+		collStatus.Name = outcome.job.coll
+		collectionStatList.Members = append(collectionStatList.Members, collStatus)
 	}
 
+	collectionStatList.ScrapeDurationSeconds = time.Since(start).Seconds()
+
 	return collectionStatList
 }
@@ -0,0 +1,185 @@
+package mongod
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	collStatsDatabases = kingpin.Flag("collector.collstats.databases",
+		"Comma-separated glob patterns of databases to collect collStats for").Default("*").String()
+	collStatsCollections = kingpin.Flag("collector.collstats.collections",
+		"Comma-separated glob patterns of db.coll namespaces to collect collStats for").Default("*").String()
+	collStatsExclude = kingpin.Flag("collector.collstats.exclude",
+		"Comma-separated glob patterns of db.coll namespaces to exclude from collStats").Default("").String()
+	collStatsLimit = kingpin.Flag("collector.collstats.limit",
+		"Maximum number of collections to run collStats against per scrape (0 = unlimited)").Default("0").Int()
+	collStatsMinSize = kingpin.Flag("collector.collstats.min-size",
+		"Minimum collection size in bytes required to export collStats for a collection (0 = no minimum)").Default("0").Int()
+	collStatsConfigFile = kingpin.Flag("collector.collstats.config",
+		"Path to a YAML file with additional collStats allow-list/exclude rules").String()
+)
+
+// CollStatsFilterConfig is the YAML shape accepted by --collector.collstats.config.
+// Rules loaded from the file are appended to the ones derived from the CLI flags.
+type CollStatsFilterConfig struct {
+	Databases   []string `yaml:"databases"`
+	Collections []string `yaml:"collections"`
+	Exclude     []string `yaml:"exclude"`
+	Limit       int      `yaml:"limit"`
+	MinSize     int      `yaml:"min_size"`
+}
+
+// CollStatsFilter decides which db.coll namespaces are eligible for collStats.
+// A zero value Filter allows everything, which keeps GetCollectionStatList's
+// default behaviour unchanged when no flags are set. A CollStatsFilter holds
+// only immutable rules, so the same instance can be shared and evaluated
+// concurrently by any number of in-flight scrapes; per-scrape --limit
+// accounting lives in the *collStatsBudget each scrape creates via NewBudget.
+type CollStatsFilter struct {
+	databases   []string
+	collections []string
+	exclude     []string
+	limit       int
+	minSize     int
+}
+
+// NewCollStatsFilter builds a filter from the --collector.collstats.* flags,
+// merging in the optional YAML config file when --collector.collstats.config
+// is set.
+func NewCollStatsFilter() (*CollStatsFilter, error) {
+	filter := &CollStatsFilter{
+		databases:   splitPatterns(*collStatsDatabases),
+		collections: splitPatterns(*collStatsCollections),
+		exclude:     splitPatterns(*collStatsExclude),
+		limit:       *collStatsLimit,
+		minSize:     *collStatsMinSize,
+	}
+
+	if *collStatsConfigFile == "" {
+		return filter, nil
+	}
+
+	raw, err := ioutil.ReadFile(*collStatsConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg CollStatsFilterConfig
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+
+	filter.databases = append(filter.databases, cfg.Databases...)
+	filter.collections = append(filter.collections, cfg.Collections...)
+	filter.exclude = append(filter.exclude, cfg.Exclude...)
+	if cfg.Limit > 0 {
+		filter.limit = cfg.Limit
+	}
+	if cfg.MinSize > 0 {
+		filter.minSize = cfg.MinSize
+	}
+
+	return filter, nil
+}
+
+func splitPatterns(raw string) []string {
+	var patterns []string
+	for _, p := range strings.Split(raw, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// matchPattern matches name against pattern. A pattern prefixed with "re:" is
+// compiled and matched as a regexp; everything else is matched as a glob via
+// filepath.Match.
+func matchPattern(pattern, name string) bool {
+	if re := strings.TrimPrefix(pattern, "re:"); re != pattern {
+		matched, err := regexp.MatchString(re, name)
+		return err == nil && matched
+	}
+
+	matched, err := filepath.Match(pattern, name)
+	return err == nil && matched
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// collStatsBudget tracks how many namespaces a single scrape has admitted
+// against a CollStatsFilter's --collector.collstats.limit. Each scrape must
+// create its own budget via NewBudget: sharing one across concurrent scrapes
+// (e.g. overlapping federated/Agent-mode pulls, or a collStats pass running
+// alongside an $indexStats pass) would let them race for the same slots and
+// silently starve one another.
+type collStatsBudget struct {
+	limit int
+
+	mu      sync.Mutex
+	scraped int
+}
+
+// NewBudget returns a fresh --collector.collstats.limit counter for one
+// scrape to pass into AllowNamespace.
+func (f *CollStatsFilter) NewBudget() *collStatsBudget {
+	return &collStatsBudget{limit: f.limit}
+}
+
+func (b *collStatsBudget) admit() bool {
+	if b == nil || b.limit <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.scraped >= b.limit {
+		return false
+	}
+	b.scraped++
+	return true
+}
+
+// AllowNamespace reports whether db.coll is eligible for collStats, and, if
+// so, reserves one of budget's --collector.collstats.limit slots. It is
+// evaluated before collStats is issued, so name-based rules never pay for
+// the round-trip of collections they are going to drop anyway.
+func (f *CollStatsFilter) AllowNamespace(db, coll string, budget *collStatsBudget) bool {
+	fullName := db + "." + coll
+
+	if matchAny(f.exclude, fullName) {
+		return false
+	}
+
+	if len(f.databases) > 0 && !matchAny(f.databases, db) {
+		return false
+	}
+
+	if len(f.collections) > 0 && !matchAny(f.collections, fullName) {
+		return false
+	}
+
+	return budget.admit()
+}
+
+// AllowSize reports whether a collection already decoded via collStats
+// clears --collector.collstats.min-size. Size is only known once collStats
+// has run, so unlike AllowNamespace this is evaluated after the fact.
+func (f *CollStatsFilter) AllowSize(sizeBytes int) bool {
+	return f.minSize <= 0 || sizeBytes >= f.minSize
+}
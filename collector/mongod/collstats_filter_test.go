@@ -0,0 +1,109 @@
+package mongod
+
+import "testing"
+
+func TestCollStatsFilterAllowNamespace(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter CollStatsFilter
+		db     string
+		coll   string
+		want   bool
+	}{
+		{
+			name:   "zero value allows everything",
+			filter: CollStatsFilter{},
+			db:     "app", coll: "users",
+			want: true,
+		},
+		{
+			name:   "glob include matches",
+			filter: CollStatsFilter{collections: []string{"app.*"}},
+			db:     "app", coll: "users",
+			want: true,
+		},
+		{
+			name:   "glob include rejects non-matching namespace",
+			filter: CollStatsFilter{collections: []string{"app.users"}},
+			db:     "app", coll: "sessions",
+			want: false,
+		},
+		{
+			name:   "re: pattern matches",
+			filter: CollStatsFilter{collections: []string{"re:^app\\.(users|sessions)$"}},
+			db:     "app", coll: "sessions",
+			want: true,
+		},
+		{
+			name:   "exclude wins over a matching include",
+			filter: CollStatsFilter{collections: []string{"app.*"}, exclude: []string{"app.users"}},
+			db:     "app", coll: "users",
+			want: false,
+		},
+		{
+			name:   "database allow-list rejects other databases",
+			filter: CollStatsFilter{databases: []string{"app"}},
+			db:     "other", coll: "users",
+			want: false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			budget := tc.filter.NewBudget()
+			if got := tc.filter.AllowNamespace(tc.db, tc.coll, budget); got != tc.want {
+				t.Errorf("AllowNamespace(%q, %q) = %v, want %v", tc.db, tc.coll, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCollStatsFilterBudgetLimit(t *testing.T) {
+	filter := CollStatsFilter{limit: 2}
+	budget := filter.NewBudget()
+
+	namespaces := []string{"a", "b", "c"}
+	var allowed int
+	for _, coll := range namespaces {
+		if filter.AllowNamespace("db", coll, budget) {
+			allowed++
+		}
+	}
+
+	if allowed != 2 {
+		t.Errorf("expected limit to admit exactly 2 namespaces, admitted %d", allowed)
+	}
+}
+
+func TestCollStatsFilterBudgetIsPerScrape(t *testing.T) {
+	filter := CollStatsFilter{limit: 1}
+
+	first := filter.NewBudget()
+	if !filter.AllowNamespace("db", "a", first) {
+		t.Fatal("expected first namespace to be admitted under a fresh budget")
+	}
+	if filter.AllowNamespace("db", "b", first) {
+		t.Fatal("expected the limit to reject a second namespace under the same budget")
+	}
+
+	second := filter.NewBudget()
+	if !filter.AllowNamespace("db", "a", second) {
+		t.Fatal("expected a new budget to admit a namespace the previous budget had already exhausted its limit on")
+	}
+}
+
+func TestCollStatsFilterAllowSize(t *testing.T) {
+	filter := CollStatsFilter{minSize: 1024}
+
+	if filter.AllowSize(512) {
+		t.Error("expected a collection below min-size to be rejected")
+	}
+	if !filter.AllowSize(2048) {
+		t.Error("expected a collection at or above min-size to be allowed")
+	}
+
+	unbounded := CollStatsFilter{}
+	if !unbounded.AllowSize(0) {
+		t.Error("expected AllowSize to allow everything when min-size is unset")
+	}
+}
@@ -0,0 +1,86 @@
+package mongod
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	collStatsConcurrency = kingpin.Flag("collector.collstats.concurrency",
+		"Maximum number of collStats commands to run concurrently during a single scrape").Default("10").Int()
+	collStatsTimeout = kingpin.Flag("collector.collstats.timeout",
+		"Timeout for a single collection's collStats command").Default("10s").Duration()
+)
+
+// collStatsJob is one (db, coll) namespace queued for a collStats command.
+type collStatsJob struct {
+	db   string
+	coll string
+}
+
+// collStatsOutcome is the result of running collStats for a single job.
+type collStatsOutcome struct {
+	job    collStatsJob
+	status CollectionStatus
+	err    error
+}
+
+// runCollStatsPool fans jobs out across collStatsConcurrency workers, each
+// bounding its collStats call with collStatsTimeout derived from ctx, and
+// returns the outcomes in no particular order. A slow or stuck collection
+// only stalls its own worker, not the whole scrape.
+func runCollStatsPool(ctx context.Context, client *mongo.Client, jobs []collStatsJob) []collStatsOutcome {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	concurrency := *collStatsConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	jobCh := make(chan collStatsJob, len(jobs))
+	resultCh := make(chan collStatsOutcome, len(jobs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				resultCh <- runCollStatsJob(ctx, client, job)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+
+	wg.Wait()
+	close(resultCh)
+
+	outcomes := make([]collStatsOutcome, 0, len(jobs))
+	for outcome := range resultCh {
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+func runCollStatsJob(ctx context.Context, client *mongo.Client, job collStatsJob) collStatsOutcome {
+	timeoutCtx, cancel := context.WithTimeout(ctx, *collStatsTimeout)
+	defer cancel()
+
+	var status CollectionStatus
+	err := client.Database(job.db).RunCommand(timeoutCtx, bson.D{{"collStats", job.coll}, {"scale", 1}}).Decode(&status)
+	return collStatsOutcome{job: job, status: status, err: err}
+}
@@ -0,0 +1,161 @@
+package mongod
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/percona/mongodb_exporter/collector/common"
+	"github.com/percona/mongodb_exporter/shared"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var indexStatsUnusedSince = kingpin.Flag("collector.indexstats.unused-since",
+	"An index with zero ops since this long ago is reported as unused via mongodb_index_unused").Default("168h").Duration()
+
+// IndexUsageAccesses is the `accesses` sub-document of one $indexStats result.
+type IndexUsageAccesses struct {
+	Ops   float64   `bson:"ops"`
+	Since time.Time `bson:"since"`
+}
+
+// IndexUsageStats is a single document returned by the $indexStats
+// aggregation stage.
+type IndexUsageStats struct {
+	Name     string             `bson:"name"`
+	Accesses IndexUsageAccesses `bson:"accesses"`
+}
+
+// IndexUsageStatus is an IndexUsageStats resolved to the db and collection it
+// was gathered from.
+type IndexUsageStatus struct {
+	Database   string
+	Collection string
+	Index      string
+	Ops        float64
+	Since      time.Time
+}
+
+// IndexUsageStatList holds index usage stats for all allowed collections.
+type IndexUsageStatList struct {
+	Members []IndexUsageStatus
+}
+
+// Unused reports whether member has recorded zero ops for at least
+// --collector.indexstats.unused-since.
+func (member *IndexUsageStatus) Unused() bool {
+	return member.Ops == 0 && time.Since(member.Since) >= *indexStatsUnusedSince
+}
+
+var logSuppressIS = shared.NewSyncStringSet()
+
+const keyIS = ""
+
+var (
+	defaultIndexStatsFilterOnce sync.Once
+	defaultIndexStatsFilter     *CollStatsFilter
+)
+
+// GetIndexUsageStatList returns $indexStats results for every index of every
+// non-system collection allowed by the --collector.collstats.* allow-list.
+func GetIndexUsageStatList(ctx context.Context, client *mongo.Client) *IndexUsageStatList {
+	defaultIndexStatsFilterOnce.Do(func() {
+		filter, err := NewCollStatsFilter()
+		if err != nil {
+			log.Warnf("%s. collStats filtering rules from --collector.collstats.config will not be applied to index usage stats.", err)
+			filter = &CollStatsFilter{}
+		}
+		defaultIndexStatsFilter = filter
+	})
+
+	return getIndexUsageStatList(ctx, client, defaultIndexStatsFilter)
+}
+
+func getIndexUsageStatList(ctx context.Context, client *mongo.Client, filter *CollStatsFilter) *IndexUsageStatList {
+	statList := &IndexUsageStatList{}
+	if filter == nil {
+		filter = &CollStatsFilter{}
+	}
+	budget := filter.NewBudget()
+
+	dbNames, err := client.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		if !logSuppressIS.Contains(keyIS) {
+			log.Warnf("%s. Index usage stats will not be collected. This log message will be suppressed from now.", err)
+			logSuppressIS.Add(keyIS)
+		}
+		return nil
+	}
+
+	logSuppressIS.Delete(keyIS)
+	var candidates []indexStatsJob
+	for _, dbName := range dbNames {
+		if common.IsSystemDB(dbName) {
+			continue
+		}
+
+		collNames, err := client.Database(dbName).ListCollectionNames(ctx, bson.M{})
+		if err != nil {
+			if !logSuppressIS.Contains(dbName) {
+				log.Warnf("%s. Index usage stats will not be collected for this db. This log message will be suppressed from now.", err)
+				logSuppressIS.Add(dbName)
+			}
+			continue
+		}
+
+		logSuppressIS.Delete(dbName)
+		for _, collName := range collNames {
+			if common.IsSystemCollection(collName) {
+				continue
+			}
+
+			candidates = append(candidates, indexStatsJob{db: dbName, coll: collName})
+		}
+	}
+
+	// Sorted identically to collections_status.go's candidate list, so that
+	// with --collector.collstats.limit set the index-usage pass admits the
+	// same deterministic subset of namespaces scrape after scrape.
+	sort.Slice(candidates, func(i, j int) bool {
+		return common.CollFullName(candidates[i].db, candidates[i].coll) < common.CollFullName(candidates[j].db, candidates[j].coll)
+	})
+
+	var jobs []indexStatsJob
+	for _, candidate := range candidates {
+		if !filter.AllowNamespace(candidate.db, candidate.coll, budget) {
+			continue
+		}
+
+		jobs = append(jobs, candidate)
+	}
+
+	for _, outcome := range runIndexStatsPool(ctx, client, jobs) {
+		fullCollName := common.CollFullName(outcome.job.db, outcome.job.coll)
+		if outcome.err != nil {
+			if !logSuppressIS.Contains(fullCollName) {
+				log.Warnf("%s. Index usage stats will not be collected for this collection. This log message will be suppressed from now.", outcome.err)
+				logSuppressIS.Add(fullCollName)
+			}
+			continue
+		}
+
+		logSuppressIS.Delete(fullCollName)
+		for _, stats := range outcome.stats {
+			statList.Members = append(statList.Members, IndexUsageStatus{
+				Database:   outcome.job.db,
+				Collection: outcome.job.coll,
+				Index:      stats.Name,
+				Ops:        stats.Accesses.Ops,
+				Since:      stats.Accesses.Since,
+			})
+		}
+	}
+
+	return statList
+}
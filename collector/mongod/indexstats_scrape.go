@@ -0,0 +1,102 @@
+package mongod
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	indexStatsConcurrency = kingpin.Flag("collector.indexstats.concurrency",
+		"Maximum number of $indexStats aggregations to run concurrently during a single scrape").Default("10").Int()
+	indexStatsTimeout = kingpin.Flag("collector.indexstats.timeout",
+		"Timeout for a single collection's $indexStats aggregation").Default("10s").Duration()
+)
+
+// indexStatsJob is one (db, coll) namespace queued for an $indexStats
+// aggregation.
+type indexStatsJob struct {
+	db   string
+	coll string
+}
+
+// indexStatsOutcome is the result of running $indexStats for a single job.
+type indexStatsOutcome struct {
+	job   indexStatsJob
+	stats []IndexUsageStats
+	err   error
+}
+
+// runIndexStatsPool fans jobs out across indexStatsConcurrency workers, each
+// bounding its $indexStats aggregation with indexStatsTimeout derived from
+// ctx, and returns the outcomes in no particular order. A slow or stuck
+// collection only stalls its own worker, not the whole scrape.
+func runIndexStatsPool(ctx context.Context, client *mongo.Client, jobs []indexStatsJob) []indexStatsOutcome {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	concurrency := *indexStatsConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	jobCh := make(chan indexStatsJob, len(jobs))
+	resultCh := make(chan indexStatsOutcome, len(jobs))
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				resultCh <- runIndexStatsJob(ctx, client, job)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+
+	wg.Wait()
+	close(resultCh)
+
+	outcomes := make([]indexStatsOutcome, 0, len(jobs))
+	for outcome := range resultCh {
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+func runIndexStatsJob(ctx context.Context, client *mongo.Client, job indexStatsJob) indexStatsOutcome {
+	timeoutCtx, cancel := context.WithTimeout(ctx, *indexStatsTimeout)
+	defer cancel()
+
+	cursor, err := client.Database(job.db).Collection(job.coll).Aggregate(timeoutCtx, mongo.Pipeline{
+		{{"$indexStats", bson.D{}}},
+	})
+	if err != nil {
+		return indexStatsOutcome{job: job, err: err}
+	}
+	defer cursor.Close(timeoutCtx)
+
+	var stats []IndexUsageStats
+	for cursor.Next(timeoutCtx) {
+		var s IndexUsageStats
+		if err := cursor.Decode(&s); err != nil {
+			continue
+		}
+		stats = append(stats, s)
+	}
+
+	return indexStatsOutcome{job: job, stats: stats, err: cursor.Err()}
+}